@@ -0,0 +1,124 @@
+// Package config loads the module's runtime configuration: ICE servers
+// (including TURN credentials) and the network-level settings needed to
+// make WebRTC actually reachable from behind NAT or inside a container.
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// configPathEnv names the environment variable used to point at the config
+// file; when unset, configPath is used instead.
+const configPathEnv = "WEBRTC_CONFIG"
+const configPath = "webrtc.json"
+
+// ICEServer mirrors webrtc.ICEServer in a JSON-friendly shape, including
+// TURN username/credential.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Config holds everything loaded from the JSON config file.
+type Config struct {
+	ICEServers []ICEServer `json:"iceServers"`
+
+	// NAT1To1IPs lists the public IPs to advertise in host candidates, for
+	// deployments behind static 1:1 NAT (e.g. a cloud load balancer).
+	NAT1To1IPs []string `json:"nat1To1Ips,omitempty"`
+	// PortRangeMin/PortRangeMax bound the UDP ports ICE will use; left at
+	// zero, pion picks ephemeral ports freely. TCP ports are not bounded.
+	PortRangeMin uint16 `json:"portRangeMin,omitempty"`
+	PortRangeMax uint16 `json:"portRangeMax,omitempty"`
+	// DisableMDNS turns off the default ".local" candidate obfuscation,
+	// which containers and most TURN-only deployments don't need.
+	DisableMDNS bool `json:"disableMdns,omitempty"`
+	// InterfaceFilter, if non-empty, restricts ICE gathering to these
+	// network interface names.
+	InterfaceFilter []string `json:"interfaceFilter,omitempty"`
+}
+
+var (
+	once   sync.Once
+	loaded Config
+)
+
+// Load reads and parses the config file the first time it's called and
+// memoizes the result; later calls return the same Config. If the file is
+// missing, a default config with the public Google STUN server is used.
+func Load() Config {
+	once.Do(func() {
+		loaded = Config{
+			ICEServers: []ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
+		}
+
+		path := os.Getenv(configPathEnv)
+		if "" == path {
+			path = configPath
+		}
+
+		data, err := os.ReadFile(path)
+		if nil != err {
+			if !os.IsNotExist(err) {
+				log.Printf("[config] Error reading %s: %s", path, err)
+			}
+			return
+		}
+
+		if err = json.Unmarshal(data, &loaded); nil != err {
+			log.Printf("[config] Error parsing %s: %s", path, err)
+		}
+	})
+	return loaded
+}
+
+// WebRTCICEServers converts the configured ICE servers into the type
+// pion/webrtc expects for webrtc.Configuration.
+func (c Config) WebRTCICEServers() []webrtc.ICEServer {
+	servers := make([]webrtc.ICEServer, len(c.ICEServers))
+	for i, server := range c.ICEServers {
+		servers[i] = webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		}
+	}
+	return servers
+}
+
+// SettingEngine builds the pion SettingEngine that applies this config's
+// NAT, port range, mDNS and interface filter knobs.
+func (c Config) SettingEngine() webrtc.SettingEngine {
+	var settingEngine webrtc.SettingEngine
+
+	if len(c.NAT1To1IPs) > 0 {
+		settingEngine.SetNAT1To1IPs(c.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+
+	if c.PortRangeMin > 0 && c.PortRangeMax > 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(c.PortRangeMin, c.PortRangeMax); nil != err {
+			log.Printf("[config] Error setting port range: %s", err)
+		}
+	}
+
+	if c.DisableMDNS {
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	}
+
+	if len(c.InterfaceFilter) > 0 {
+		allowed := make(map[string]bool, len(c.InterfaceFilter))
+		for _, name := range c.InterfaceFilter {
+			allowed[name] = true
+		}
+		settingEngine.SetInterfaceFilter(func(name string) bool { return allowed[name] })
+	}
+
+	return settingEngine
+}
@@ -0,0 +1,49 @@
+package egress
+
+import (
+	"fmt"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// rtpWriter is satisfied by both ivfwriter.IVFWriter and oggwriter.OggWriter.
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// FileSink records a single track's RTP stream to disk, using pion's
+// IVFWriter for VP8 video and OggWriter for Opus audio.
+type FileSink struct {
+	writer rtpWriter
+}
+
+// NewVP8FileSink creates a FileSink that writes VP8 video to path as IVF.
+func NewVP8FileSink(path string) (*FileSink, error) {
+	writer, err := ivfwriter.New(path)
+	if nil != err {
+		return nil, fmt.Errorf("creating IVF writer for %s: %w", path, err)
+	}
+	return &FileSink{writer: writer}, nil
+}
+
+// NewOpusFileSink creates a FileSink that writes Opus audio to path as Ogg.
+func NewOpusFileSink(path string) (*FileSink, error) {
+	writer, err := oggwriter.New(path, 48000, 2)
+	if nil != err {
+		return nil, fmt.Errorf("creating Ogg writer for %s: %w", path, err)
+	}
+	return &FileSink{writer: writer}, nil
+}
+
+// WriteRTP hands packet to the underlying media writer.
+func (s *FileSink) WriteRTP(packet *rtp.Packet) error {
+	return s.writer.WriteRTP(packet)
+}
+
+// Close finalizes the file, e.g. writing the IVF/Ogg trailer.
+func (s *FileSink) Close() error {
+	return s.writer.Close()
+}
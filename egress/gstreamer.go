@@ -0,0 +1,46 @@
+//go:build gst
+
+package egress
+
+import (
+	"github.com/pion/rtp"
+
+	"github.com/lnogueir/stream-anything/internal/gst"
+)
+
+// GStreamerSink appsrc-injects whole RTP packets into a user-supplied
+// GStreamer pipeline string, e.g. for on-the-fly transcoding.
+//
+// Building with this sink requires the gst build tag and the GStreamer 1.0
+// + gstreamer-app development packages, which is why it's opt-in rather
+// than part of the default build.
+type GStreamerSink struct {
+	pipeline *gst.Pipeline
+}
+
+// NewGStreamerSink parses pipelineStr (which must contain an appsrc element
+// named "src") and starts it.
+func NewGStreamerSink(pipelineStr string) (*GStreamerSink, error) {
+	pipeline, err := gst.NewPipeline(pipelineStr)
+	if nil != err {
+		return nil, err
+	}
+	pipeline.Start()
+	return &GStreamerSink{pipeline: pipeline}, nil
+}
+
+// WriteRTP pushes packet's raw bytes into the pipeline's appsrc.
+func (s *GStreamerSink) WriteRTP(packet *rtp.Packet) error {
+	packetBytes, err := packet.Marshal()
+	if nil != err {
+		return err
+	}
+	s.pipeline.Push(packetBytes)
+	return nil
+}
+
+// Close stops and releases the underlying pipeline.
+func (s *GStreamerSink) Close() error {
+	s.pipeline.Close()
+	return nil
+}
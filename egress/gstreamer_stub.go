@@ -0,0 +1,26 @@
+//go:build !gst
+
+package egress
+
+import (
+	"fmt"
+
+	"github.com/pion/rtp"
+)
+
+// GStreamerSink is unavailable in this build; rebuild with -tags gst (and
+// the GStreamer 1.0 + gstreamer-app development packages installed) to use
+// it.
+type GStreamerSink struct{}
+
+// NewGStreamerSink always returns an error in a non-gst build.
+func NewGStreamerSink(pipelineStr string) (*GStreamerSink, error) {
+	return nil, fmt.Errorf("gst sink not available: rebuild with -tags gst")
+}
+
+// WriteRTP is a no-op; GStreamerSink is never successfully constructed
+// without the gst build tag.
+func (s *GStreamerSink) WriteRTP(packet *rtp.Packet) error { return nil }
+
+// Close is a no-op; see WriteRTP.
+func (s *GStreamerSink) Close() error { return nil }
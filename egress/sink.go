@@ -0,0 +1,13 @@
+// Package egress defines the pluggable outputs an ingested RTP stream can
+// be routed to: a UDP forwarder, a file recorder, or a GStreamer pipeline.
+package egress
+
+import "github.com/pion/rtp"
+
+// Sink receives a stream's RTP packets until Close is called. A Sink
+// attaches to a wshandles.Stream the same way a WebRTC playback peer does,
+// but consumes raw packets instead of negotiating media over SDP.
+type Sink interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
@@ -0,0 +1,54 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pion/rtp"
+)
+
+// UDPSink forwards RTP packets to a fixed destination, rewriting the
+// payload type the way the server's original one-shot UDP forwarder did.
+type UDPSink struct {
+	conn        *net.UDPConn
+	payloadType uint8
+}
+
+// NewUDPSink dials addr and returns a Sink that forwards every packet to it
+// with PayloadType overridden to payloadType.
+func NewUDPSink(addr string, payloadType uint8) (*UDPSink, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if nil != err {
+		return nil, fmt.Errorf("resolving %s: %w", addr, err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if nil != err {
+		return nil, fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	return &UDPSink{conn: conn, payloadType: payloadType}, nil
+}
+
+// WriteRTP rewrites packet's payload type and forwards it over UDP. A
+// connection-refused error (no listener bound yet) is swallowed rather than
+// tearing down the sink, matching the tolerant behavior of the original
+// forwarder.
+func (s *UDPSink) WriteRTP(packet *rtp.Packet) error {
+	packet.PayloadType = s.payloadType
+	packetBytes, err := packet.Marshal()
+	if nil != err {
+		return fmt.Errorf("marshalling RTP packet: %w", err)
+	}
+
+	if _, err = s.conn.Write(packetBytes); nil != err {
+		if opError, ok := err.(*net.OpError); ok && opError.Err.Error() == "write: connection refused" {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Close releases the underlying UDP socket.
+func (s *UDPSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,55 @@
+//go:build gst
+
+// Package gst wraps a minimal GStreamer pipeline driven by an appsrc
+// element named "src", letting Go code inject arbitrary RTP payloads into a
+// user-supplied pipeline string. It mirrors the cgo appsrc pattern used by
+// pion's own rtp-forwarder examples.
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-app-1.0
+#include "gst.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Pipeline is a not-yet-started or running GStreamer pipeline.
+type Pipeline struct {
+	handle *C.GstElement
+}
+
+// NewPipeline parses pipelineStr, which must contain an appsrc element
+// named "src" to receive pushed buffers.
+func NewPipeline(pipelineStr string) (*Pipeline, error) {
+	pipelineStrC := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrC))
+
+	var errMessageC *C.char
+	handle := C.gstreamer_send_create_pipeline(pipelineStrC, &errMessageC)
+	if nil == handle {
+		defer C.free(unsafe.Pointer(errMessageC))
+		return nil, fmt.Errorf("gst: %s", C.GoString(errMessageC))
+	}
+	return &Pipeline{handle: handle}, nil
+}
+
+// Start transitions the pipeline to the PLAYING state.
+func (p *Pipeline) Start() {
+	C.gstreamer_send_start_pipeline(p.handle)
+}
+
+// Push injects buffer into the pipeline's appsrc element.
+func (p *Pipeline) Push(buffer []byte) {
+	cBuffer := C.CBytes(buffer)
+	defer C.free(cBuffer)
+	C.gstreamer_send_push_buffer(p.handle, cBuffer, C.int(len(buffer)))
+}
+
+// Close stops the pipeline and releases its GStreamer resources.
+func (p *Pipeline) Close() {
+	C.gstreamer_send_stop_pipeline(p.handle)
+}
@@ -9,6 +9,15 @@ import (
 
 func main() {
 	http.HandleFunc("/webrtc", wshandles.WebRTCHandle)
+	http.HandleFunc("/whip", wshandles.WHIPHandle)
+	http.HandleFunc("/whip/resources/", wshandles.WHIPHandle)
+	// "/whep/" is the subtree clients POST "/whep/<streamId>" to in order to
+	// start watching a stream; "/whep/resources/" is registered separately
+	// (and wins on requests under it, since ServeMux prefers the longest
+	// matching pattern) for PATCH/DELETE against the resulting playback
+	// session.
+	http.HandleFunc("/whep/", wshandles.WHEPHandle)
+	http.HandleFunc("/whep/resources/", wshandles.WHEPHandle)
 	const addr = "localhost:8080"
 	log.Printf("WebSocket listening at %s", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
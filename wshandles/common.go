@@ -22,6 +22,11 @@ type baseHandle struct {
 }
 
 func (handle *baseHandle) send(messageStruct interface{}) {
+	if nil == handle.wsConn {
+		// Handles driven over plain HTTP (e.g. WHIP/WHEP) have no socket to
+		// notify; status/warning/error messages are simply dropped.
+		return
+	}
 	message, _ := json.Marshal(messageStruct)
 	handle.mutex.Lock()
 	defer handle.mutex.Unlock()
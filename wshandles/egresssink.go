@@ -0,0 +1,34 @@
+package wshandles
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/lnogueir/stream-anything/egress"
+)
+
+// newEgressSink builds the egress.Sink requested by the client's "start"
+// message for a track of the given kind, or returns (nil, nil) if no sink
+// was requested.
+func (handle *webrtcHandle) newEgressSink(kind webrtc.RTPCodecType) (egress.Sink, error) {
+	switch handle.sink {
+	case "", "none":
+		return nil, nil
+	case "udp":
+		payloadType := uint8(111)
+		if webrtc.RTPCodecTypeVideo == kind {
+			payloadType = 96
+		}
+		return egress.NewUDPSink(handle.sinkTarget, payloadType)
+	case "file":
+		if webrtc.RTPCodecTypeVideo == kind {
+			return egress.NewVP8FileSink(fmt.Sprintf("%s-video.ivf", handle.sinkTarget))
+		}
+		return egress.NewOpusFileSink(fmt.Sprintf("%s-audio.ogg", handle.sinkTarget))
+	case "gst":
+		return egress.NewGStreamerSink(handle.sinkTarget)
+	default:
+		return nil, fmt.Errorf("unknown sink %q", handle.sink)
+	}
+}
@@ -0,0 +1,43 @@
+package wshandles
+
+import "github.com/gorilla/websocket"
+
+// errorKind classifies a failure so it can be mapped to an appropriate
+// WebSocket close code when the connection is torn down because of it.
+type errorKind int
+
+const (
+	// internalError is our own fault: an unexpected failure in WebRTC/OS
+	// plumbing that isn't the client's doing.
+	internalError errorKind = iota
+	// protocolError means the client sent something we can't make sense
+	// of, or called a command out of sequence.
+	protocolError
+	// userError means the request was well-formed but not something we
+	// can or will service (e.g. no such stream).
+	userError
+)
+
+// closeCode returns the WebSocket close code to use when ending a
+// connection for this reason.
+func (k errorKind) closeCode() int {
+	switch k {
+	case protocolError:
+		return websocket.CloseProtocolError
+	case userError:
+		return websocket.ClosePolicyViolation
+	default:
+		return websocket.CloseInternalServerErr
+	}
+}
+
+// handleError pairs an error with the errorKind used to close the
+// connection it occurred on.
+type handleError struct {
+	kind errorKind
+	err  error
+}
+
+func (e *handleError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,265 @@
+package wshandles
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lithammer/shortuuid"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/lnogueir/stream-anything/config"
+)
+
+const sdpContentType = "application/sdp"
+const trickleICESdpFragContentType = "application/trickle-ice-sdpfrag"
+
+const whipResourcePrefix = "/whip/resources/"
+const whepResourcePrefix = "/whep/resources/"
+
+// resourceEntry pairs the webrtcHandle backing a WHIP/WHEP resource URL with
+// the streams it subscribed to (WHEP only), so DELETE can both close the
+// peer connection and release those subscriptions.
+type resourceEntry struct {
+	handle  *webrtcHandle
+	streams []*Stream
+}
+
+// resourceRegistry tracks each WHIP/WHEP resource URL so later PATCH
+// (trickle ICE) and DELETE (teardown) requests on that resource can be
+// routed back to the right peer connection.
+var resourceRegistry = struct {
+	sync.Mutex
+	entries map[string]resourceEntry
+}{entries: make(map[string]resourceEntry)}
+
+// WHIPHandle implements the WHIP (WebRTC-HTTP Ingestion Protocol) publish
+// endpoint: POST an SDP offer to start ingesting, PATCH the returned
+// Location to trickle ICE candidates, DELETE it to tear the session down.
+func WHIPHandle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handleIngestPost(w, r, "whip", whipResourcePrefix)
+	case http.MethodPatch:
+		handleResourcePatch(w, r, whipResourcePrefix)
+	case http.MethodDelete:
+		handleResourceDelete(w, r, whipResourcePrefix)
+	default:
+		w.Header().Set("Allow", "POST, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WHEPHandle implements the WHEP (WebRTC-HTTP Egress Protocol) playback
+// endpoint: POST an SDP offer to start watching the stream named by the
+// request path, PATCH/DELETE the returned Location as with WHIP.
+func WHEPHandle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handlePlaybackPost(w, r, whepResourcePrefix)
+	case http.MethodPatch:
+		handleResourcePatch(w, r, whepResourcePrefix)
+	case http.MethodDelete:
+		handleResourceDelete(w, r, whepResourcePrefix)
+	default:
+		w.Header().Set("Allow", "POST, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleIngestPost(w http.ResponseWriter, r *http.Request, logTag, resourcePrefix string) {
+	offerSdp, err := readSdpBody(r)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	handle := &webrtcHandle{baseHandle: baseHandle{id: shortuuid.New()}, state: notStarted}
+	handle.streamName = resourcePrefix + handle.id
+	if err = handle.initializePeerConnection(); nil != err {
+		log.Printf("[%s=%s] Error initializing peer connection: %s", logTag, handle.id, err)
+		http.Error(w, "failed to initialize peer connection", http.StatusInternalServerError)
+		return
+	}
+	handle.mutex.Lock()
+	handle.state = readyToBegin
+	handle.mutex.Unlock()
+
+	streamPath := handle.streamName
+
+	answerSdp, err := handle.answerOffer(offerSdp)
+	if nil != err {
+		log.Printf("[%s=%s] Error negotiating offer: %s", logTag, handle.id, err)
+		http.Error(w, "failed to negotiate offer", http.StatusInternalServerError)
+		return
+	}
+
+	resourceRegistry.Lock()
+	resourceRegistry.entries[streamPath] = resourceEntry{handle: handle}
+	resourceRegistry.Unlock()
+	handle.OnClose = func() { forgetResource(streamPath) }
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", streamPath)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answerSdp)
+}
+
+func handlePlaybackPost(w http.ResponseWriter, r *http.Request, resourcePrefix string) {
+	offerSdp, err := readSdpBody(r)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ingestName := whipResourcePrefix + strings.TrimPrefix(r.URL.Path, "/whep/")
+	var streams []*Stream
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if stream, ok := streamRegistry.Get(streamKey(ingestName, kind)); ok {
+			streams = append(streams, stream)
+		}
+	}
+	if 0 == len(streams) {
+		http.Error(w, fmt.Sprintf("no live stream at %s", ingestName), http.StatusNotFound)
+		return
+	}
+
+	handle := &webrtcHandle{baseHandle: baseHandle{id: shortuuid.New()}, state: notStarted}
+	pcConfig := webrtc.Configuration{ICEServers: config.Load().WebRTCICEServers()}
+	var err2 error
+	if handle.peerConn, err2 = webrtcAPI.NewPeerConnection(pcConfig); nil != err2 {
+		log.Printf("[whep=%s] Error creating peer connection: %s", handle.id, err2)
+		http.Error(w, "failed to initialize peer connection", http.StatusInternalServerError)
+		return
+	}
+	for _, stream := range streams {
+		if _, err2 = handle.peerConn.AddTrack(stream.Track); nil != err2 {
+			log.Printf("[whep=%s] Error adding track: %s", handle.id, err2)
+			http.Error(w, "failed to attach to stream", http.StatusInternalServerError)
+			return
+		}
+		stream.Subscribe()
+	}
+	handle.setupOnConnectionStateChange()
+	handle.mutex.Lock()
+	handle.state = readyToBegin
+	handle.mutex.Unlock()
+
+	answerSdp, err := handle.answerOffer(offerSdp)
+	if nil != err {
+		log.Printf("[whep=%s] Error negotiating offer: %s", handle.id, err)
+		http.Error(w, "failed to negotiate offer", http.StatusInternalServerError)
+		return
+	}
+
+	streamPath := resourcePrefix + handle.id
+	resourceRegistry.Lock()
+	resourceRegistry.entries[streamPath] = resourceEntry{handle: handle, streams: streams}
+	resourceRegistry.Unlock()
+	handle.OnClose = func() { forgetResource(streamPath) }
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", streamPath)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answerSdp)
+}
+
+func handleResourcePatch(w http.ResponseWriter, r *http.Request, resourcePrefix string) {
+	if ct := r.Header.Get("Content-Type"); ct != trickleICESdpFragContentType {
+		http.Error(w, fmt.Sprintf("unexpected Content-Type %q, want %q", ct, trickleICESdpFragContentType), http.StatusBadRequest)
+		return
+	}
+
+	entry, ok := lookupResource(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	sdpFrag, err := io.ReadAll(r.Body)
+	if nil != err {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	for _, line := range strings.Split(string(sdpFrag), "\r\n") {
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		candidate := strings.TrimPrefix(line, "a=")
+		if err = entry.handle.takeCandidate(candidate); nil != err {
+			log.Printf("[%s] Error adding trickled candidate: %s", strings.TrimPrefix(r.URL.Path, resourcePrefix), err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleResourceDelete(w http.ResponseWriter, r *http.Request, resourcePrefix string) {
+	entry, ok := lookupResource(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// entry.handle.teardown fires OnClose, which calls forgetResource to drop
+	// the entry and release its stream subscriptions.
+	entry.handle.teardown(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func lookupResource(path string) (resourceEntry, bool) {
+	resourceRegistry.Lock()
+	defer resourceRegistry.Unlock()
+	entry, ok := resourceRegistry.entries[path]
+	return entry, ok
+}
+
+// forgetResource removes path's entry from resourceRegistry and releases
+// any stream subscriptions it held (WHEP only). It runs from a handle's
+// OnClose hook, whether teardown was triggered by an explicit DELETE or by
+// the peer connection dropping on its own, and is safe to call twice for
+// the same path.
+func forgetResource(path string) {
+	resourceRegistry.Lock()
+	entry, ok := resourceRegistry.entries[path]
+	if ok {
+		delete(resourceRegistry.entries, path)
+	}
+	resourceRegistry.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, stream := range entry.streams {
+		stream.Unsubscribe()
+	}
+}
+
+func readSdpBody(r *http.Request) (string, error) {
+	if ct := r.Header.Get("Content-Type"); ct != sdpContentType {
+		return "", fmt.Errorf("unexpected Content-Type %q, want %q", ct, sdpContentType)
+	}
+	body, err := io.ReadAll(r.Body)
+	if nil != err {
+		return "", fmt.Errorf("failed to read body: %w", err)
+	}
+	return string(body), nil
+}
+
+// answerOffer takes the given SDP offer, waits for ICE gathering to finish
+// (WHIP/WHEP are non-trickle by default on the answer side) and returns the
+// resulting SDP answer.
+func (handle *webrtcHandle) answerOffer(offerSdp string) (string, error) {
+	gatherComplete := webrtc.GatheringCompletePromise(handle.peerConn)
+	if err := handle.takeOffer(offerSdp); nil != err {
+		return "", err
+	}
+	<-gatherComplete
+	handle.mutex.Lock()
+	handle.state = streaming
+	handle.mutex.Unlock()
+	return handle.peerConn.LocalDescription().SDP, nil
+}
@@ -0,0 +1,89 @@
+package wshandles
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// jitterBufferDelay bounds how long a packet sits in the jitter buffer
+// waiting for earlier-sequenced packets to arrive before it's released
+// anyway.
+const jitterBufferDelay = 50 * time.Millisecond
+
+// jitterBuffer reorders RTP packets by sequence number, holding a packet
+// that follows a sequence gap for up to jitterBufferDelay (checked every
+// jitterBufferDelay/4), smoothing over the reordering a lossy or multi-path
+// network link introduces. Packets leave strictly in buffered sequence
+// order, so a packet can wait longer than jitterBufferDelay while an
+// earlier-sequenced one ahead of it is still inside its own window. It is a
+// small fixed-delay reorder queue, not a full adaptive RFC 3550 jitter
+// buffer.
+type jitterBuffer struct {
+	delay time.Duration
+
+	mutex   sync.Mutex
+	packets map[uint16]bufferedPacket
+}
+
+type bufferedPacket struct {
+	packet    *rtp.Packet
+	arrivedAt time.Time
+}
+
+func newJitterBuffer(delay time.Duration) *jitterBuffer {
+	return &jitterBuffer{delay: delay, packets: make(map[uint16]bufferedPacket)}
+}
+
+// Push buffers packet for later release by Run.
+func (j *jitterBuffer) Push(packet *rtp.Packet) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.packets[packet.SequenceNumber] = bufferedPacket{packet: packet, arrivedAt: time.Now()}
+}
+
+// Run releases buffered packets in sequence-number order, in the arrival
+// order they'd have had without reordering, until done is closed. A packet
+// is held back only while there's still time left in the delay window for
+// an earlier-sequenced packet to show up; once that window expires it's
+// released regardless of gaps, so a single lost packet doesn't stall the
+// stream forever.
+func (j *jitterBuffer) Run(done <-chan struct{}, onRelease func(*rtp.Packet)) {
+	ticker := time.NewTicker(j.delay / 4)
+	defer ticker.Stop()
+
+	nextSeq := uint16(0)
+	haveNextSeq := false
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			j.mutex.Lock()
+			seqs := make([]uint16, 0, len(j.packets))
+			for seq := range j.packets {
+				seqs = append(seqs, seq)
+			}
+			// Compare as a signed 16-bit difference, not a plain uint16 <,
+			// so sequence numbers sort correctly across the 65535->0 wrap.
+			sort.Slice(seqs, func(i, k int) bool { return int16(seqs[i]-seqs[k]) < 0 })
+
+			for _, seq := range seqs {
+				buffered := j.packets[seq]
+				if !haveNextSeq {
+					nextSeq, haveNextSeq = seq, true
+				}
+				if seq != nextSeq && now.Sub(buffered.arrivedAt) < j.delay {
+					break
+				}
+				delete(j.packets, seq)
+				onRelease(buffered.packet)
+				nextSeq = seq + 1
+			}
+			j.mutex.Unlock()
+		}
+	}
+}
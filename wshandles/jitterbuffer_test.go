@@ -0,0 +1,61 @@
+package wshandles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func runJitterBuffer(t *testing.T, seqs []uint16) []uint16 {
+	t.Helper()
+
+	buffer := newJitterBuffer(10 * time.Millisecond)
+	done := make(chan struct{})
+	runDone := make(chan struct{})
+
+	var released []uint16
+	go func() {
+		buffer.Run(done, func(p *rtp.Packet) {
+			released = append(released, p.SequenceNumber)
+		})
+		close(runDone)
+	}()
+
+	for _, seq := range seqs {
+		buffer.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	<-runDone
+
+	return released
+}
+
+func TestJitterBufferReleasesInSequenceOrder(t *testing.T) {
+	got := runJitterBuffer(t, []uint16{3, 1, 2, 0})
+	want := []uint16{0, 1, 2, 3}
+	assertSeqsEqual(t, got, want)
+}
+
+// TestJitterBufferHandlesSequenceNumberWraparound covers the 65535->0
+// rollover: a plain numeric sort would release 0,1,2 before 65534,65535,
+// even though those come first in sequence order.
+func TestJitterBufferHandlesSequenceNumberWraparound(t *testing.T) {
+	got := runJitterBuffer(t, []uint16{1, 65535, 0, 2, 65534})
+	want := []uint16{65534, 65535, 0, 1, 2}
+	assertSeqsEqual(t, got, want)
+}
+
+func assertSeqsEqual(t *testing.T, got, want []uint16) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("released %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("released %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package wshandles
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Stream is one published RTP track, identified by name, that any number of
+// subscribers can attach to concurrently: WebRTC playback peers read
+// directly off Track, while non-WebRTC consumers (UDP sinks, recorders)
+// call SubscribeRaw to receive a copy of every packet. It reference counts
+// subscribers so callers know when a stream is no longer being watched.
+type Stream struct {
+	name string
+
+	mutex       sync.Mutex
+	Track       *webrtc.TrackLocalStaticRTP
+	webrtcSubs  int
+	rawSubs     map[int]chan *rtp.Packet
+	nextRawSub  int
+	onLastUnsub func()
+
+	// RequestKeyframe, if non-nil, asks the publisher for a new key frame
+	// (e.g. via PLI). Subscribe calls it automatically so a viewer joining
+	// mid-stream has somewhere to start decoding.
+	RequestKeyframe func() error
+}
+
+// WriteRTP forwards packet to the shared TrackLocalStaticRTP (feeding every
+// attached WebRTC subscriber) and to every raw subscriber's channel.
+func (s *Stream) WriteRTP(packet *rtp.Packet) {
+	if err := s.Track.WriteRTP(packet); nil != err {
+		log.Printf("[stream=%s] Track.WriteRTP returned error: %s", s.name, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ch := range s.rawSubs {
+		// Each subscriber gets its own copy of the packet struct, so one
+		// rewriting header fields (e.g. UDPSink's PayloadType) can't affect
+		// another.
+		packetCopy := *packet
+		select {
+		case ch <- &packetCopy:
+		default:
+			// Slow subscriber; drop the packet rather than block the publisher.
+		}
+	}
+}
+
+// Subscribe registers a WebRTC viewer's interest in Track, bumping the
+// reference count, and requests a fresh key frame so the new viewer isn't
+// stuck waiting for the next one the publisher would have sent anyway.
+// Pair with Unsubscribe once the viewer detaches.
+func (s *Stream) Subscribe() {
+	s.mutex.Lock()
+	s.webrtcSubs++
+	requestKeyframe := s.RequestKeyframe
+	s.mutex.Unlock()
+
+	if nil != requestKeyframe {
+		if err := requestKeyframe(); nil != err {
+			log.Printf("[stream=%s] RequestKeyframe returned error: %s", s.name, err)
+		}
+	}
+}
+
+// Unsubscribe drops a WebRTC viewer's reference, firing onLastUnsub once no
+// subscriber of any kind remains.
+func (s *Stream) Unsubscribe() {
+	s.mutex.Lock()
+	s.webrtcSubs--
+	s.notifyIfEmptyLocked()
+	s.mutex.Unlock()
+}
+
+// SubscribeRaw returns a channel fed with a copy of every RTP packet
+// published to the stream, and an unsubscribe function that must be called
+// once the consumer is done reading.
+func (s *Stream) SubscribeRaw() (<-chan *rtp.Packet, func()) {
+	s.mutex.Lock()
+	id := s.nextRawSub
+	s.nextRawSub++
+	ch := make(chan *rtp.Packet, 32)
+	s.rawSubs[id] = ch
+	s.mutex.Unlock()
+
+	return ch, func() {
+		s.mutex.Lock()
+		delete(s.rawSubs, id)
+		close(ch)
+		s.notifyIfEmptyLocked()
+		s.mutex.Unlock()
+	}
+}
+
+func (s *Stream) notifyIfEmptyLocked() {
+	if s.webrtcSubs <= 0 && 0 == len(s.rawSubs) && nil != s.onLastUnsub {
+		s.onLastUnsub()
+	}
+}
+
+// StreamRegistry is a process-wide, name-keyed lookup of live streams. It
+// replaces dialing a fixed UDP port per track with a rendezvous point that
+// any number of publishers and subscribers can share.
+type StreamRegistry struct {
+	mutex   sync.Mutex
+	streams map[string]*Stream
+}
+
+var streamRegistry = &StreamRegistry{streams: make(map[string]*Stream)}
+
+// Register publishes track under name, returning an error if name is
+// already taken. onLastUnsub, if non-nil, fires once every subscriber has
+// detached. requestKeyframe is wired up as the returned Stream's
+// RequestKeyframe.
+func (r *StreamRegistry) Register(name string, track *webrtc.TrackLocalStaticRTP, onLastUnsub func(), requestKeyframe func() error) (*Stream, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.streams[name]; exists {
+		return nil, fmt.Errorf("stream %q already registered", name)
+	}
+	stream := &Stream{
+		name:            name,
+		Track:           track,
+		rawSubs:         make(map[int]chan *rtp.Packet),
+		onLastUnsub:     onLastUnsub,
+		RequestKeyframe: requestKeyframe,
+	}
+	r.streams[name] = stream
+	return stream, nil
+}
+
+// Unregister removes name from the registry, e.g. once its publisher
+// disconnects.
+func (r *StreamRegistry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.streams, name)
+}
+
+// Get returns the stream published under name, if any.
+func (r *StreamRegistry) Get(name string) (*Stream, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stream, ok := r.streams[name]
+	return stream, ok
+}
@@ -0,0 +1,103 @@
+package wshandles
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func newTestTrack(t *testing.T) *webrtc.TrackLocalStaticRTP {
+	t.Helper()
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "test")
+	if nil != err {
+		t.Fatalf("NewTrackLocalStaticRTP: %s", err)
+	}
+	return track
+}
+
+func TestStreamRegistryRejectsDuplicateNames(t *testing.T) {
+	registry := &StreamRegistry{streams: make(map[string]*Stream)}
+	track := newTestTrack(t)
+
+	if _, err := registry.Register("a", track, nil, nil); nil != err {
+		t.Fatalf("first Register: %s", err)
+	}
+	if _, err := registry.Register("a", track, nil, nil); nil == err {
+		t.Fatal("second Register with the same name should have failed")
+	}
+}
+
+func TestStreamRegistryGetAndUnregister(t *testing.T) {
+	registry := &StreamRegistry{streams: make(map[string]*Stream)}
+	track := newTestTrack(t)
+
+	stream, err := registry.Register("a", track, nil, nil)
+	if nil != err {
+		t.Fatalf("Register: %s", err)
+	}
+	if got, ok := registry.Get("a"); !ok || got != stream {
+		t.Fatalf("Get(%q) = (%v, %v), want (%v, true)", "a", got, ok, stream)
+	}
+
+	registry.Unregister("a")
+	if _, ok := registry.Get("a"); ok {
+		t.Fatal("Get after Unregister should report not found")
+	}
+}
+
+func TestStreamUnsubscribeFiresOnLastUnsubOnlyWhenEmpty(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	stream := &Stream{
+		name:        "a",
+		Track:       newTestTrack(t),
+		rawSubs:     make(map[int]chan *rtp.Packet),
+		onLastUnsub: func() { fired <- struct{}{} },
+	}
+
+	stream.Subscribe()
+	stream.Subscribe()
+	stream.Unsubscribe()
+	select {
+	case <-fired:
+		t.Fatal("onLastUnsub fired with a subscriber still attached")
+	default:
+	}
+
+	stream.Unsubscribe()
+	select {
+	case <-fired:
+	default:
+		t.Fatal("onLastUnsub did not fire once the last subscriber left")
+	}
+}
+
+func TestStreamSubscribeRawFansOutToEverySubscriber(t *testing.T) {
+	stream := &Stream{
+		name:    "a",
+		Track:   newTestTrack(t),
+		rawSubs: make(map[int]chan *rtp.Packet),
+	}
+
+	ch1, unsub1 := stream.SubscribeRaw()
+	ch2, unsub2 := stream.SubscribeRaw()
+	defer unsub2()
+
+	stream.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1}})
+
+	for _, ch := range []<-chan *rtp.Packet{ch1, ch2} {
+		select {
+		case got := <-ch:
+			if 1 != got.SequenceNumber {
+				t.Errorf("got sequence number %d, want 1", got.SequenceNumber)
+			}
+		default:
+			t.Error("expected a packet on the subscriber channel")
+		}
+	}
+
+	unsub1()
+	if _, ok := <-ch1; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+}
@@ -4,22 +4,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/lithammer/shortuuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
+
+	"github.com/lnogueir/stream-anything/config"
 )
 
 var mediaEngine *webrtc.MediaEngine
 var webrtcAPI *webrtc.API
 
+// nackResponderPacketCache is how many recently-sent packets per track the
+// NACK responder interceptor keeps around to satisfy retransmit requests.
+// Must be a power of two.
+const nackResponderPacketCache = 512
+
 func init() {
 	mediaEngine = new(webrtc.MediaEngine)
 	mediaEngine.RegisterDefaultCodecs()
-	webrtcAPI = webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+
+	interceptorRegistry := &interceptor.Registry{}
+
+	generator, err := nack.NewGeneratorInterceptor()
+	if nil != err {
+		log.Fatalf("[webrtc] Error creating NACK generator interceptor: %s", err)
+	}
+	interceptorRegistry.Add(generator)
+
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(nackResponderPacketCache))
+	if nil != err {
+		log.Fatalf("[webrtc] Error creating NACK responder interceptor: %s", err)
+	}
+	interceptorRegistry.Add(responder)
+
+	if err = webrtc.ConfigureRTCPReports(interceptorRegistry); nil != err {
+		log.Fatalf("[webrtc] Error configuring RTCP report interceptors: %s", err)
+	}
+
+	settingEngine := config.Load().SettingEngine()
+	webrtcAPI = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	)
 	log.Print("[webrtc] Initialized")
 }
 
@@ -43,12 +78,39 @@ type webrtcMessage struct {
 	Candidate     string  `json:"candidate,omitempty"`
 	SDPMid        string  `json:"sdpMid,omitempty"`
 	SDPMLineIndex *uint16 `json:"sdpMLineIndex,omitempty"`
+	StreamName    string  `json:"streamName,omitempty"`
+	// Sink selects an egress.Sink to attach to the ingested tracks: "udp"
+	// (SinkTarget is a host:port), "file" (SinkTarget is a path prefix) or
+	// "gst" (SinkTarget is a pipeline string). Omitted or "none" attaches no
+	// sink at all, relying solely on the stream registry for fan-out.
+	Sink       string `json:"sink,omitempty"`
+	SinkTarget string `json:"sinkTarget,omitempty"`
 }
 
 type webrtcHandle struct {
 	baseHandle
-	state    webrtcState
-	peerConn *webrtc.PeerConnection
+	state      webrtcState
+	peerConn   *webrtc.PeerConnection
+	streamName string
+	sink       string
+	sinkTarget string
+
+	teardownOnce sync.Once
+	// OnConnected, if set, fires once the peer connection reaches the
+	// Connected state.
+	OnConnected func()
+	// OnClose, if set, fires once the handle has torn down -- the peer
+	// connection failed, disconnected, or was explicitly closed -- letting
+	// code embedding this package observe lifecycle events (e.g. the WHIP
+	// resource registry in httphandle.go) without patching this switch
+	// statement.
+	OnClose func()
+}
+
+// streamKey namespaces a registry entry by both the stream's name and its
+// track kind, since a single publisher registers one Stream per kind.
+func streamKey(streamName string, kind webrtc.RTPCodecType) string {
+	return fmt.Sprintf("%s:%s", streamName, kind)
 }
 
 //WebRTCHandle handles webrtc related requests
@@ -63,6 +125,11 @@ func WebRTCHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer handle.wsConn.Close()
+	defer func() {
+		if nil != handle.peerConn {
+			handle.peerConn.Close()
+		}
+	}()
 
 	var errMessage string
 	for {
@@ -96,6 +163,13 @@ func WebRTCHandle(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			handle.streamName = parsedMessage.StreamName
+			if "" == handle.streamName {
+				handle.streamName = handle.id
+			}
+			handle.sink = parsedMessage.Sink
+			handle.sinkTarget = parsedMessage.SinkTarget
+
 			if err = handle.initializePeerConnection(); nil != err {
 				errMessage = fmt.Sprintf("Error initializing peer connection: %s", err)
 				handle.mutex.Lock()
@@ -103,6 +177,7 @@ func WebRTCHandle(w http.ResponseWriter, r *http.Request) {
 				handle.mutex.Unlock()
 				log.Printf("[webrtc=%s] %s", handle.id, errMessage)
 				handle.sendError(errMessage)
+				handle.teardown(&handleError{kind: internalError, err: err})
 				return
 			}
 			log.Printf("[webrtc=%s] Successfully initialized peer connection", handle.id)
@@ -130,6 +205,7 @@ func WebRTCHandle(w http.ResponseWriter, r *http.Request) {
 				handle.mutex.Unlock()
 				log.Printf("[webrtc=%s] %s", handle.id, errMessage)
 				handle.sendError(errMessage)
+				handle.teardown(&handleError{kind: protocolError, err: err})
 				return
 			}
 
@@ -161,12 +237,8 @@ func WebRTCHandle(w http.ResponseWriter, r *http.Request) {
 
 func (handle *webrtcHandle) initializePeerConnection() error {
 	var err error
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-		},
-	}
-	if handle.peerConn, err = webrtcAPI.NewPeerConnection(config); nil != err {
+	pcConfig := webrtc.Configuration{ICEServers: config.Load().WebRTCICEServers()}
+	if handle.peerConn, err = webrtcAPI.NewPeerConnection(pcConfig); nil != err {
 		return err
 	}
 
@@ -226,79 +298,141 @@ func (handle *webrtcHandle) setupOnConnectionStateChange() {
 			handle.mutex.Lock()
 			handle.state = streaming
 			handle.mutex.Unlock()
-			// here I have to figure out how to generate SDP for UDP stream and send it through ws
+			if nil != handle.OnConnected {
+				handle.OnConnected()
+			}
+
 		case webrtc.PeerConnectionStateFailed:
 			handle.mutex.Lock()
 			handle.state = failed
 			handle.mutex.Unlock()
-			fallthrough
-		case webrtc.PeerConnectionStateClosed:
-			fallthrough
+			handle.teardown(&handleError{kind: internalError, err: fmt.Errorf("peer connection failed")})
+
 		case webrtc.PeerConnectionStateDisconnected:
-			// Here I should handle when user connection gets diconnected
+			handle.teardown(&handleError{kind: protocolError, err: fmt.Errorf("peer connection disconnected")})
+
+		case webrtc.PeerConnectionStateClosed:
+			handle.teardown(nil)
+		}
+	})
+}
+
+// teardown runs at most once per handle, however many terminal connection
+// states fire or however many callers (e.g. an explicit WHIP/WHEP DELETE)
+// ask for it: it closes the peer connection -- which unblocks any
+// in-flight track.Read so its goroutine and stream registration clean up
+// -- fires OnClose, and closes the underlying websocket, if any, with a
+// close code derived from closeErr.
+func (handle *webrtcHandle) teardown(closeErr *handleError) {
+	handle.teardownOnce.Do(func() {
+		if nil != handle.peerConn {
+			handle.peerConn.Close()
+		}
+
+		if nil != handle.OnClose {
+			handle.OnClose()
+		}
+
+		if nil == handle.wsConn {
+			return
 		}
+		code, reason := websocket.CloseNormalClosure, ""
+		if nil != closeErr {
+			code, reason = closeErr.kind.closeCode(), closeErr.Error()
+		}
+		deadline := time.Now().Add(time.Second)
+		handle.wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
 	})
 }
 
+// setupOnTrack publishes each incoming track into the process-wide
+// streamRegistry under handle.streamName instead of forwarding it to a
+// fixed UDP port, so any number of subscribers can attach to it.
 func (handle *webrtcHandle) setupOnTrack() {
 	handle.peerConn.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Printf("[webrtc=%s] Received %s track", handle.id, track.Kind())
-		var err error
-		var errMessage string
-		var udpConn *net.UDPConn
-		var raddr *net.UDPAddr
-		var payloadType uint8
-		switch track.Kind() {
-		case webrtc.RTPCodecTypeAudio:
-			payloadType = 111
-			raddr, err = net.ResolveUDPAddr("udp", "127.0.0.1:4000")
-			if nil != err {
-				log.Printf("[webrtc=%s] net.ResolveUDPAddr returned error: %s", handle.id, err)
-			}
-		case webrtc.RTPCodecTypeVideo:
-			payloadType = 96
-			raddr, err = net.ResolveUDPAddr("udp", "127.0.0.1:4002")
-			if nil != err {
-				log.Printf("[webrtc=%s] net.ResolveUDPAddr returned error: %s", handle.id, err)
-			}
+
+		localTrack, err := webrtc.NewTrackLocalStaticRTP(track.Codec().RTPCodecCapability, track.Kind().String(), handle.streamName)
+		if nil != err {
+			errMessage := fmt.Sprintf("Error creating local track: %s", err)
+			log.Printf("[webrtc=%s] %s", handle.id, errMessage)
+			handle.sendError(errMessage)
+			return
 		}
-		laddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:")
-		udpConn, err = net.DialUDP("udp", laddr, raddr)
+
+		name := streamKey(handle.streamName, track.Kind())
+		stream, err := streamRegistry.Register(name, localTrack, func() {
+			log.Printf("[webrtc=%s] Stream %q has no more subscribers", handle.id, name)
+		}, func() error {
+			return handle.requestKeyframe(track.SSRC())
+		})
 		if nil != err {
-			errMessage = fmt.Sprintf("Error creating UDP connection: %s", err)
+			errMessage := fmt.Sprintf("Error registering stream: %s", err)
 			log.Printf("[webrtc=%s] %s", handle.id, errMessage)
 			handle.sendError(errMessage)
 			return
 		}
+		defer streamRegistry.Unregister(name)
+
+		sink, err := handle.newEgressSink(track.Kind())
+		if nil != err {
+			errMessage := fmt.Sprintf("Error creating egress sink: %s", err)
+			log.Printf("[webrtc=%s] %s", handle.id, errMessage)
+			handle.sendError(errMessage)
+			// The client chose the sink, so a bad choice (e.g. an unknown
+			// sink name or an unparseable GStreamer pipeline) is on them,
+			// not us -- close with userError rather than internalError.
+			handle.teardown(&handleError{kind: userError, err: err})
+			return
+		}
+		if nil != sink {
+			rawPackets, unsubscribe := stream.SubscribeRaw()
+			var drainDone sync.WaitGroup
+			drainDone.Add(1)
+			defer sink.Close()
+			defer drainDone.Wait()
+			defer unsubscribe()
+			go func() {
+				defer drainDone.Done()
+				for packet := range rawPackets {
+					if err := sink.WriteRTP(packet); nil != err {
+						log.Printf("[webrtc=%s] sink.WriteRTP returned error: %s", handle.id, err)
+					}
+				}
+			}()
+		}
+
+		// The jitter buffer is the only thing that calls stream.WriteRTP,
+		// so every subscriber -- WebRTC viewers and raw egress sinks alike
+		// -- sees packets in order rather than however track.Read delivers
+		// them.
+		buffer := newJitterBuffer(jitterBufferDelay)
+		done := make(chan struct{})
+		defer close(done)
+		go buffer.Run(done, stream.WriteRTP)
 
-		packetBytes := make([]byte, 1500)
-		rtpPacket := &rtp.Packet{}
 		for {
-			// Read
+			packetBytes := make([]byte, 1500)
 			n, _, err := track.Read(packetBytes)
 			if nil != err {
 				log.Printf("[webrtc=%s] track.Read returned error: %s", handle.id, err)
+				return
 			}
 
-			// Unmarshal the packet and update the PayloadType
+			rtpPacket := &rtp.Packet{}
 			if err = rtpPacket.Unmarshal(packetBytes[:n]); nil != err {
 				log.Printf("[webrtc=%s] rtpPacket.Unmarshal returned error: %s", handle.id, err)
-			}
-			rtpPacket.PayloadType = payloadType
-
-			// Marshal into original buffer with updated PayloadType
-			if n, err = rtpPacket.MarshalTo(packetBytes); err != nil {
-				log.Printf("[webrtc=%s] rtpPacket.MarshalTo returned error: %s", handle.id, err)
+				continue
 			}
 
-			// Write
-			if _, err = udpConn.Write(packetBytes[:n]); err != nil {
-				if opError, ok := err.(*net.OpError); ok && opError.Err.Error() == "write: connection refused" {
-					continue
-				}
-				log.Printf("[webrtc=%s] udpConn.Write returned error: %s", handle.id, err)
-			}
-			// log.Printf("[webrtc=%s] Sent %d bytes RTP packet", handle.id, n)
+			buffer.Push(rtpPacket)
 		}
 	})
 }
+
+// requestKeyframe asks the peer sending ssrc for a new key frame by
+// emitting a PictureLossIndication RTCP packet, e.g. when a new subscriber
+// needs somewhere to start decoding.
+func (handle *webrtcHandle) requestKeyframe(ssrc webrtc.SSRC) error {
+	return handle.peerConn.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}})
+}
@@ -1,14 +0,0 @@
-package wshandles
-
-import (
-	"net/http"
-
-	"github.com/gorilla/websocket"
-)
-
-var wsUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// allow all connections
-		return true
-	},
-}